@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -13,28 +13,69 @@ import (
 	"strings"
 	"time"
 
+	"github.com/btcsuite/btclog"
 	"github.com/lightningnetwork/lnd/lncfg"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
 	"github.com/urfave/cli"
 	macaroon "gopkg.in/macaroon.v2"
+	"gopkg.in/yaml.v2"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/protobuf/proto"
 )
 
+// log is the guard's leveled, subsystem-tagged logger. It's suitable for
+// systemd/journald deployment, unlike the ad-hoc fmt.Println calls the
+// rest of this file still uses for direct operator-facing CLI output
+// (e.g. the macaroon printed by `guard bake`).
+var log btclog.Logger
+
+func init() {
+	backend := btclog.NewBackend(os.Stdout)
+	log = backend.Logger("GRD")
+	log.SetLevel(btclog.LevelInfo)
+}
+
 const (
-	defaultRPCPort         = "10009"
-	defaultRPCHostPort     = "localhost:" + defaultRPCPort
-	FwdingHistoryCaveat_1d = "1d_FwdingHistory"
-	FwdingHistoryCaveat_1w = "1w_FwdingHistory"
+	defaultRPCPort     = "10009"
+	defaultRPCHostPort = "localhost:" + defaultRPCPort
+
+	// FwdingHistoryCaveat is the name of the custom macaroon caveat this
+	// guard registers with lnd's RPC middleware. Its condition carries
+	// the look-back window the macaroon is pinned to, e.g. "-30d", so a
+	// single running guard can serve macaroons baked with many different
+	// windows instead of requiring one guard per window.
+	FwdingHistoryCaveat = "FwdingHistory"
+
+	// defaultNetwork is the network used to locate the per-network
+	// config directory when --network isn't set.
+	defaultNetwork = "mainnet"
+
+	// defaultGuardDir is the faraday-style base directory config files
+	// are loaded from, namespaced by network, e.g.
+	// ~/.fwdhist-guard/mainnet/config.yaml.
+	defaultGuardDir = "~/.fwdhist-guard"
+
+	// defaultConfigFilename is the name of the config file within a
+	// network's guard directory.
+	defaultConfigFilename = "config.yaml"
+
+	// reconnectBackoff is how long a caveat worker waits before
+	// re-registering middleware after its stream errors out.
+	reconnectBackoff = 5 * time.Second
 )
 
 var (
 	defaultLndDir      = cleanAndExpandPath("~/.lnd")
 	defaultMacaroonDir = "/data/chain/bitcoin/regtest"
 
+	// defaultMaxEvents caps the number of forwarding events a single
+	// guarded ForwardingHistory call will return, regardless of how
+	// wide the requested look-back window is.
+	defaultMaxEvents uint = 100_000
+
 	// maxMsgRecvSize is the largest message our client will receive. We
 	// set this to 200MiB atm.
 	maxMsgRecvSize = grpc.MaxCallRecvMsgSize(1 * 1024 * 1024 * 200)
@@ -43,169 +84,715 @@ var (
 func main() {
 	app := &cli.App{
 		Name: "guard",
-		Usage: "Intercepts `lncli fwdinghistory` calls, checks " +
-			"the custom macaroon caveat and replaces the response with" +
-			" the appropriate forwarding history report",
+		Usage: "Runs a long-lived service that intercepts RPC calls, " +
+			"checks the custom macaroon caveat and replaces the response " +
+			"with history trimmed to the caveat's look-back window",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:  "window",
-				Value: "-1d",
-				Usage: "How many days of forwarding history should be retrieved?" +
-					"Possible values are -1d(past day), -1w(past week).",
+				Name:  "network",
+				Value: defaultNetwork,
+				Usage: "the network lnd is running on, used to locate " +
+					"the per-network config directory, e.g. mainnet, " +
+					"testnet, regtest",
+			},
+			&cli.StringFlag{
+				Name: "configfile",
+				Usage: "(optional) path to a YAML config file listing " +
+					"the caveats this guard should serve; defaults to " +
+					defaultGuardDir + "/<network>/" + defaultConfigFilename,
+			},
+			&cli.StringFlag{
+				Name:  "max-window",
+				Value: "-1w",
+				Usage: "(used only if no config file is found) the " +
+					"maximum look-back window a macaroon presented to " +
+					"this guard is allowed to request, e.g. -1d(past " +
+					"day), -1w(past week). Requests whose caveat " +
+					"condition exceeds this are rejected.",
+			},
+			&cli.UintFlag{
+				Name:  "max-events",
+				Value: defaultMaxEvents,
+				Usage: "(used only if no config file is found) the " +
+					"maximum number of forwarding events returned for a " +
+					"single ForwardingHistory request. If the look-back " +
+					"window contains more events than this, the result " +
+					"is trimmed and the guard logs a truncation warning " +
+					"server-side; lnd's RPC middleware feedback has no " +
+					"way to pass a non-fatal warning back to the " +
+					"calling client, so the client itself cannot " +
+					"currently detect the trim.",
 			},
 			&cli.StringFlag{
 				Name:  "macaroon",
 				Value: defaultLndDir + defaultMacaroonDir + "/admin.macaroon",
-				Usage: "admin macaroon for this lnd instance",
+				Usage: "(used only if no config file is found) admin " +
+					"macaroon for this lnd instance",
 			},
 			&cli.StringFlag{
 				Name:  "cert",
 				Value: defaultLndDir + "/tls.cert",
-				Usage: "tls certificate for this lnd instance",
+				Usage: "(used only if no config file is found) tls " +
+					"certificate for this lnd instance",
 			},
 			&cli.StringFlag{
 				Name:  "host",
 				Value: defaultRPCHostPort,
-				Usage: "host:port of this lnd's rpc instance, e.g. localhost:10009",
+				Usage: "(used only if no config file is found) host:port " +
+					"of this lnd's rpc instance, e.g. localhost:10009",
 			},
 		},
 		Action: fwdingHistoryGuard,
+		Commands: []cli.Command{
+			bakeCommand,
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		log.Criticalf("%v", err)
+		os.Exit(1)
 	}
 }
 
-// fwdingHistoryGuard registers RPC middleware that returns
-// forwarding events within a given time frame, e.g. -1d or -1w
-func fwdingHistoryGuard(cliCtx *cli.Context) error {
+// CaveatConfig describes a single macaroon caveat a guard process should
+// register RPC middleware for, along with the maximum look-back window and
+// event cap enforced on macaroons carrying that caveat.
+type CaveatConfig struct {
+	Name      string `yaml:"name"`
+	MaxWindow string `yaml:"max_window"`
+	MaxEvents uint32 `yaml:"max_events"`
+}
 
-	var window string
-	var macaroon string
-	var cert string
-	var host string
+// GuardConfig is the on-disk configuration for a long-lived guard process,
+// loaded from ~/.fwdhist-guard/<network>/config.yaml following the
+// faraday-style per-network directory layout implied by defaultMacaroonDir.
+type GuardConfig struct {
+	Macaroon string         `yaml:"macaroon"`
+	Cert     string         `yaml:"cert"`
+	Host     string         `yaml:"host"`
+	Caveats  []CaveatConfig `yaml:"caveats"`
+}
 
-	switch {
-	case cliCtx.IsSet("window"):
-		window = cliCtx.String("window")
-		fmt.Println("window is set: ", window)
-	case cliCtx.String("window") != "":
-		fmt.Println("Default window is: ", cliCtx.String("window"))
-		window = cliCtx.String("window")
-	default:
-		return fmt.Errorf("Look up window required, please in --window")
+// loadGuardConfig loads a GuardConfig from --configfile (or its per-network
+// default location). If no config file exists there, it falls back to a
+// single caveat built from the legacy --macaroon/--cert/--host/--max-window/
+// --max-events flags, so a single-tenant guard doesn't need a config file.
+func loadGuardConfig(cliCtx *cli.Context) (*GuardConfig, error) {
+	network := cliCtx.String("network")
+
+	configPath := cliCtx.String("configfile")
+	if configPath == "" {
+		configPath = cleanAndExpandPath(filepath.Join(
+			defaultGuardDir, network, defaultConfigFilename,
+		))
 	}
 
+	configBytes, err := ioutil.ReadFile(configPath)
 	switch {
-	case cliCtx.IsSet("macaroon"):
-		macaroon = cliCtx.String("macaroon")
-		fmt.Println("Macaroon is set: ", macaroon)
-	case cliCtx.String("macaroon") != "":
-		fmt.Println("Default macaroon is: ", cliCtx.String("macaroon"))
-		macaroon = cliCtx.String("macaroon")
-	default:
-		return fmt.Errorf("macaroon required, please specify absolute path in --macaroon")
-	}
+	case err == nil:
+		var cfg GuardConfig
+		if err := yaml.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", configPath, err)
+		}
+
+		log.Infof("Loaded config from %s", configPath)
+
+		if err := validateCaveatConfigs(cfg.Caveats); err != nil {
+			return nil, err
+		}
+
+		return &cfg, nil
+
+	case os.IsNotExist(err):
+		log.Infof("No config found at %s, falling back to CLI flags",
+			configPath)
+
+		cfg := &GuardConfig{
+			Macaroon: cliCtx.String("macaroon"),
+			Cert:     cliCtx.String("cert"),
+			Host:     cliCtx.String("host"),
+			Caveats: []CaveatConfig{
+				{
+					Name:      FwdingHistoryCaveat,
+					MaxWindow: cliCtx.String("max-window"),
+					MaxEvents: uint32(cliCtx.Uint("max-events")),
+				},
+			},
+		}
+
+		if err := validateCaveatConfigs(cfg.Caveats); err != nil {
+			return nil, err
+		}
+
+		return cfg, nil
 
-	switch {
-	case cliCtx.IsSet("cert"):
-		cert = cliCtx.String("cert")
-		fmt.Println("Cert is set: ", cert)
-	case cliCtx.String("cert") != "":
-		fmt.Println("Default cert is: ", cliCtx.String("cert"))
-		cert = cliCtx.String("cert")
 	default:
-		return fmt.Errorf("tls cert required, please specify absolute path in --cert")
+		return nil, err
 	}
-	switch {
-	case cliCtx.IsSet("host"):
-		host = cliCtx.String("host")
-		fmt.Println("Host is set: ", host)
-		break
-	case cliCtx.String("host") != "":
-		fmt.Println("Default host:port is: ", cliCtx.String("host"))
-		host = cliCtx.String("host")
-	default:
-		return fmt.Errorf("RPC host:port required, please specify host:port in --host")
+}
+
+// validateCaveatConfigs rejects an invalid max_window up front, at config
+// load time, rather than letting the guard start and then reject every
+// intercepted request for that caveat in validateWindow. It also
+// defaults an unset max_events to defaultMaxEvents: GetFwdingHistory treats
+// 0 as "no cap", and a YAML config that omits max_events would otherwise
+// silently reintroduce the unbounded-response blowup the cap exists to
+// prevent.
+func validateCaveatConfigs(caveats []CaveatConfig) error {
+	for i, caveat := range caveats {
+		if _, err := parseWindow(caveat.MaxWindow); err != nil {
+			return fmt.Errorf("invalid max_window for caveat %q: %w",
+				caveat.Name, err)
+		}
+
+		if caveat.MaxEvents == 0 {
+			caveats[i].MaxEvents = uint32(defaultMaxEvents)
+		}
 	}
 
-	fmt.Println("Starting forwarding history guard...")
+	return nil
+}
+
+// bakeCommand mints a macaroon that is both scoped to ForwardingHistory alone
+// and pinned to a look-back window enforced by a running guard process, so
+// an operator can
+// hand out a single macaroon instead of wiring up `lncli bakemacaroon` and a
+// hand-crafted caveat string themselves.
+var bakeCommand = cli.Command{
+	Name:  "bake",
+	Usage: "bake a macaroon scoped to ForwardingHistory and pinned to a look-back window",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "window",
+			Value: "-1d",
+			Usage: "look-back window the baked macaroon is pinned to, " +
+				"e.g. -1d(past day), -1w(past week).",
+		},
+		&cli.StringFlag{
+			Name:  "macaroon",
+			Value: defaultLndDir + defaultMacaroonDir + "/admin.macaroon",
+			Usage: "admin macaroon for this lnd instance",
+		},
+		&cli.StringFlag{
+			Name:  "cert",
+			Value: defaultLndDir + "/tls.cert",
+			Usage: "tls certificate for this lnd instance",
+		},
+		&cli.StringFlag{
+			Name:  "host",
+			Value: defaultRPCHostPort,
+			Usage: "host:port of this lnd's rpc instance, e.g. localhost:10009",
+		},
+		&cli.StringFlag{
+			Name: "save-to",
+			Usage: "(optional) file to write the baked macaroon to, in " +
+				"addition to printing it as hex",
+		},
+	},
+	Action: bakeFwdingHistoryMacaroon,
+}
 
-	conn, err := getClientConn(macaroon, cert, host)
+// bakeFwdingHistoryMacaroon bakes a new macaroon restricted to the
+// ForwardingHistory RPC, attaches a FwdingHistoryCaveat pinned to --window,
+// and prints the result as hex. RegisterRPCMiddleware is deliberately left
+// out: that RPC is only ever called by the guard process itself (over its
+// own admin macaroon), and granting it to a handed-out client macaroon
+// would let the holder register their own interceptors.
+func bakeFwdingHistoryMacaroon(cliCtx *cli.Context) error {
+	window := cliCtx.String("window")
+	macPath := cliCtx.String("macaroon")
+	cert := cliCtx.String("cert")
+	host := cliCtx.String("host")
+
+	conn, err := getClientConn(macPath, cert, host)
 	if err != nil {
-		e := "Couldn't establish client connection to lnd." +
-			" Please check macaroon/cert/host. \n%w"
-		return fmt.Errorf(e, err)
+		return fmt.Errorf("couldn't establish client connection to "+
+			"lnd, please check macaroon/cert/host: %w", err)
 	}
 	client := lnrpc.NewLightningClient(conn)
-	rpcMiddlewareClient, err := client.RegisterRPCMiddleware(context.Background())
+
+	bakeResp, err := client.BakeMacaroon(
+		context.Background(), &lnrpc.BakeMacaroonRequest{
+			Permissions: []*lnrpc.MacaroonPermission{
+				{
+					Entity: "uri",
+					Action: "/lnrpc.Lightning/ForwardingHistory",
+				},
+			},
+		},
+	)
 	if err != nil {
-		return fmt.Errorf("Couldn't register guard RPC middleware %w", err)
+		return fmt.Errorf("couldn't bake macaroon: %w", err)
+	}
+
+	mac := &macaroon.Macaroon{}
+	if err := mac.UnmarshalBinary(bakeResp.Macaroon); err != nil {
+		return fmt.Errorf("unable to decode baked macaroon: %w", err)
+	}
+
+	if _, err := parseWindow(window); err != nil {
+		return fmt.Errorf("invalid --window %q: %w", window, err)
 	}
 
-	forwardingWindow := FwdingHistoryCaveat_1d
-	if window == "-1w" {
-		forwardingWindow = FwdingHistoryCaveat_1w
+	// lnd only treats a caveat as a "custom" one eligible for
+	// CustomCaveatCondition extraction if its condition is prefixed with
+	// "lnd-custom ", followed by the registered caveat name and value.
+	caveatID := "lnd-custom " + FwdingHistoryCaveat + " " + window
+	if err := mac.AddFirstPartyCaveat([]byte(caveatID)); err != nil {
+		return fmt.Errorf("unable to add forwarding history caveat: %w", err)
+	}
+
+	macBytes, err := mac.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("unable to serialize macaroon: %w", err)
+	}
+
+	macHex := hex.EncodeToString(macBytes)
+	fmt.Println(macHex)
+
+	saveTo := cliCtx.String("save-to")
+	if saveTo == "" {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(saveTo, []byte(macHex), 0644); err != nil {
+		return fmt.Errorf("unable to write macaroon to %s: %w", saveTo, err)
+	}
+	fmt.Println("Wrote macaroon to", saveTo)
+
+	return nil
+}
+
+// fwdingHistoryGuard is the long-lived guard service. It registers RPC
+// middleware for every caveat in the config in parallel, over a single
+// shared connection, reconnecting each caveat's stream independently if it
+// errors out rather than taking the whole process down with it.
+func fwdingHistoryGuard(cliCtx *cli.Context) error {
+	cfg, err := loadGuardConfig(cliCtx)
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+
+	conn, err := getClientConn(cfg.Macaroon, cfg.Cert, cfg.Host)
+	if err != nil {
+		return fmt.Errorf("couldn't establish client connection to lnd, "+
+			"please check macaroon/cert/host: %w", err)
+	}
+	defer conn.Close()
+
+	client := lnrpc.NewLightningClient(conn)
+
+	log.Infof("Starting forwarding history guard for %d caveat(s)",
+		len(cfg.Caveats))
+
+	ctx := context.Background()
+	errChan := make(chan error, len(cfg.Caveats))
+	for _, caveat := range cfg.Caveats {
+		caveat := caveat
+		go func() {
+			errChan <- runCaveatWorker(ctx, client, caveat)
+		}()
+	}
+
+	return <-errChan
+}
+
+// runCaveatWorker services a single caveat's middleware stream for as long
+// as the guard runs, reconnecting with a fixed backoff whenever the stream
+// errors out instead of panicking the whole process.
+func runCaveatWorker(
+	ctx context.Context, client lnrpc.LightningClient,
+	caveat CaveatConfig) error {
+
+	for {
+		err := serveCaveatStream(ctx, client, caveat)
+
+		log.Errorf("middleware stream for caveat %q failed: %v, "+
+			"reconnecting in %s", caveat.Name, err, reconnectBackoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectBackoff):
+		}
+	}
+}
+
+// serveCaveatStream registers RPC middleware for a single caveat and
+// services intercepted requests until the stream errors out.
+func serveCaveatStream(
+	ctx context.Context, client lnrpc.LightningClient,
+	caveat CaveatConfig) error {
+
+	rpcMiddlewareClient, err := client.RegisterRPCMiddleware(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't register guard RPC middleware: %w", err)
 	}
 
-	// Register interceptor immediately
 	err = rpcMiddlewareClient.Send(&lnrpc.RPCMiddlewareResponse{
 		MiddlewareMessage: &lnrpc.RPCMiddlewareResponse_Register{
 			Register: &lnrpc.MiddlewareRegistration{
-				MiddlewareName:           "FwdingHistoryGuard",
-				CustomMacaroonCaveatName: forwardingWindow,
+				MiddlewareName:           "FwdingHistoryGuard-" + caveat.Name,
+				CustomMacaroonCaveatName: caveat.Name,
 				ReadOnlyMode:             false,
 			},
 		},
 	})
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("unable to register middleware: %w", err)
 	}
 
-	fmt.Println("Registered middleware stream")
-
-	fmt.Println("Listening to middleware stream")
+	log.Infof("Registered middleware for caveat %q (max window %s, "+
+		"max events %d)", caveat.Name, caveat.MaxWindow, caveat.MaxEvents)
 
 	for {
 		resp, err := rpcMiddlewareClient.Recv()
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("middleware stream recv failed: %w", err)
 		}
 
-		fwdingHistory := GetFwdingHistory(client, context.Background(), window)
+		req := resp.GetRequest()
+		if req == nil {
+			continue
+		}
 
-		res, err := proto.Marshal(fwdingHistory)
+		feedback := &lnrpc.InterceptFeedback{}
+		window, err := validateWindow(req.CustomCaveatCondition, caveat.MaxWindow)
+		if err != nil {
+			feedback.Error = err.Error()
+		} else {
+			replacement, warning, err := replaceForWindow(
+				client, ctx, req.MethodFullUri, window, caveat.MaxEvents,
+			)
+			if err != nil {
+				feedback.Error = err.Error()
+			} else {
+				res, err := proto.Marshal(replacement)
+				if err != nil {
+					return fmt.Errorf("unable to marshal replacement "+
+						"response: %w", err)
+				}
+
+				feedback.ReplaceResponse = true
+				feedback.ReplacementSerialized = res
+
+				// warning is non-fatal: the trimmed data is still
+				// useful, so log it instead of setting
+				// feedback.Error, which lnd treats as aborting the
+				// call and would drop the replacement entirely.
+				//
+				// KNOWN LIMITATION: InterceptFeedback has no field
+				// for a non-fatal warning, only Error (which aborts)
+				// and the replacement payload itself, so there is
+				// currently no way to surface truncation to the
+				// calling client in-band. This warning is
+				// server-side/operator-visible only. Surfacing it to
+				// the client would need either a new lnd proto field
+				// or a client-observable convention embedded in the
+				// replacement data, neither of which exists today.
+				if warning != "" {
+					log.Warnf("caveat %q: %s", caveat.Name, warning)
+				}
+			}
+		}
 
 		err = rpcMiddlewareClient.Send(&lnrpc.RPCMiddlewareResponse{
 			RefMsgId: resp.GetMsgId(),
 			MiddlewareMessage: &lnrpc.RPCMiddlewareResponse_Feedback{
-				Feedback: &lnrpc.InterceptFeedback{
-					Error:                 "",
-					ReplaceResponse:       true,
-					ReplacementSerialized: res,
-				},
+				Feedback: feedback,
 			},
 		})
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("unable to send middleware feedback: %w", err)
+		}
+	}
+}
+
+// validateWindow checks that the look-back window carried by a macaroon's
+// caveat condition does not request further back than maxWindow allows,
+// and returns it parsed as a time.Duration for use by replaceForWindow.
+func validateWindow(condition, maxWindow string) (time.Duration, error) {
+	window, err := parseWindow(condition)
+	if err != nil {
+		return 0, fmt.Errorf("invalid caveat condition %q: %w", condition, err)
+	}
+
+	max, err := parseWindow(maxWindow)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max-window %q: %w", maxWindow, err)
+	}
+
+	if window > max {
+		return 0, fmt.Errorf("requested window %q exceeds max allowed "+
+			"window %q", condition, maxWindow)
+	}
+
+	return window, nil
+}
+
+// replaceForWindow builds the trimmed replacement response for the given
+// fully qualified RPC method, restricted to the caveat's look-back window.
+// It is the dispatcher that lets a single guard serve several read-heavy
+// lnd queries instead of just ForwardingHistory. The returned warning is
+// non-empty when maxEvents trimmed the ForwardingHistory result.
+func replaceForWindow(
+	client lnrpc.LightningClient, ctx context.Context, method string,
+	window time.Duration, maxEvents uint32) (proto.Message, string, error) {
+
+	switch method {
+	case "/lnrpc.Lightning/ForwardingHistory":
+		resp, truncated, err := GetFwdingHistory(client, ctx, window, maxEvents)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var warning string
+		if truncated {
+			warning = fmt.Sprintf("forwarding history truncated at "+
+				"--max-events=%d, result is incomplete", maxEvents)
+		}
+
+		return resp, warning, nil
+
+	case "/lnrpc.Lightning/ListPayments":
+		resp, err := GetPayments(client, ctx, window)
+		return resp, "", err
+
+	case "/lnrpc.Lightning/ListInvoices":
+		resp, err := GetInvoices(client, ctx, window)
+		return resp, "", err
+
+	case "/lnrpc.Lightning/GetTransactions":
+		resp, err := GetTransactionHistory(client, ctx, window)
+		return resp, "", err
+
+	default:
+		return nil, "", fmt.Errorf("no window guard registered for "+
+			"method %q", method)
+	}
+}
+
+// fwdingHistoryPageSize is the number of events requested per
+// ForwardingHistory page. Paginating keeps any single response well under
+// maxMsgRecvSize even on busy routing nodes with wide look-back windows.
+const fwdingHistoryPageSize = 10_000
+
+// GetFwdingHistory fetches forwarding events within the given look-back
+// window, paginating through lnd's ForwardingHistory RPC via IndexOffset/
+// NumMaxEvents rather than issuing a single unbounded request. It stops
+// once the window is exhausted or maxEvents have been collected, whichever
+// comes first, and reports via the bool return whether maxEvents trimmed
+// the result.
+func GetFwdingHistory(
+	client lnrpc.LightningClient, ctx context.Context, window time.Duration,
+	maxEvents uint32) (*lnrpc.ForwardingHistoryResponse, bool, error) {
+
+	startTime := uint64(time.Now().Add(-window).Unix())
+
+	var (
+		events     []*lnrpc.ForwardingEvent
+		offset     uint32
+		lastOffset uint32
+		truncated  bool
+	)
+
+	for {
+		resp, err := client.ForwardingHistory(
+			ctx, &lnrpc.ForwardingHistoryRequest{
+				StartTime:    startTime,
+				IndexOffset:  offset,
+				NumMaxEvents: fwdingHistoryPageSize,
+			},
+		)
+		if err != nil {
+			return nil, false, err
+		}
+
+		priorCount := uint32(len(events))
+		events = append(events, resp.ForwardingEvents...)
+		lastOffset = resp.LastOffsetIndex
+
+		if maxEvents > 0 && uint32(len(events)) >= maxEvents {
+			// Hitting the cap exactly on a short (final) page drops
+			// nothing. A full page may have more events behind it, so
+			// it is reported as trimmed.
+			truncated = uint32(len(events)) > maxEvents ||
+				uint32(len(resp.ForwardingEvents)) == fwdingHistoryPageSize
+			events = events[:maxEvents]
+
+			// lastOffset must point at the last event we actually
+			// kept, not the last one lnd returned in this page, or a
+			// paging client would skip the events we dropped.
+			lastOffset = offset + (maxEvents - priorCount)
+			break
+		}
+
+		if uint32(len(resp.ForwardingEvents)) < fwdingHistoryPageSize {
+			break
+		}
+
+		offset = resp.LastOffsetIndex
+	}
+
+	return &lnrpc.ForwardingHistoryResponse{
+		ForwardingEvents: events,
+		LastOffsetIndex:  lastOffset,
+	}, truncated, nil
+}
+
+// paymentsPageSize is the number of payments requested per ListPayments
+// page when walking backwards from the most recent payment.
+const paymentsPageSize = 1000
+
+// GetPayments returns payments created within the given look-back window.
+// lnd returns payments oldest-first and caps an unpaginated call at its own
+// default, which can cut off the very payments a recent window cares
+// about, so this walks the index backwards (Reversed) a page at a time and
+// stops as soon as it reaches a payment older than the window.
+func GetPayments(
+	client lnrpc.LightningClient, ctx context.Context,
+	window time.Duration) (proto.Message, error) {
+
+	cutoff := time.Now().Add(-window).UnixNano()
+
+	var (
+		payments []*lnrpc.Payment
+		offset   uint64
+	)
+
+	for {
+		resp, err := client.ListPayments(ctx, &lnrpc.ListPaymentsRequest{
+			IndexOffset: offset,
+			MaxPayments: paymentsPageSize,
+			Reversed:    true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Payments) == 0 {
+			break
+		}
+
+		exhausted := false
+		for _, payment := range resp.Payments {
+			if payment.CreationTimeNs < cutoff {
+				exhausted = true
+				break
+			}
+			payments = append(payments, payment)
 		}
+		if exhausted {
+			break
+		}
+
+		offset = resp.FirstIndexOffset
 	}
 
+	return &lnrpc.ListPaymentsResponse{Payments: payments}, nil
 }
 
-func GetFwdingHistory(client lnrpc.LightningClient, ctx context.Context, window string) *lnrpc.ForwardingHistoryResponse {
-	now := time.Now()
-	startTime, _ := parseTime(window, now)
+// invoicesPageSize is the number of invoices requested per ListInvoices
+// page when walking backwards from the most recent invoice.
+const invoicesPageSize = 1000
+
+// GetInvoices returns invoices created within the given look-back window,
+// walking ListInvoices backwards (Reversed) a page at a time for the same
+// reason GetPayments does: an unpaginated, oldest-first call can cap out
+// before reaching the recent invoices the window is asking for.
+func GetInvoices(
+	client lnrpc.LightningClient, ctx context.Context,
+	window time.Duration) (proto.Message, error) {
+
+	cutoff := time.Now().Add(-window).Unix()
+
+	var (
+		invoices []*lnrpc.Invoice
+		offset   uint64
+	)
+
+	for {
+		resp, err := client.ListInvoices(ctx, &lnrpc.ListInvoiceRequest{
+			IndexOffset:    offset,
+			NumMaxInvoices: invoicesPageSize,
+			Reversed:       true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Invoices) == 0 {
+			break
+		}
 
-	req := &lnrpc.ForwardingHistoryRequest{
-		StartTime: startTime,
+		exhausted := false
+		for _, invoice := range resp.Invoices {
+			if invoice.CreationDate < cutoff {
+				exhausted = true
+				break
+			}
+			invoices = append(invoices, invoice)
+		}
+		if exhausted {
+			break
+		}
+
+		offset = resp.FirstIndexOffset
+	}
+
+	return &lnrpc.ListInvoiceResponse{Invoices: invoices}, nil
+}
+
+// avgBlockIntervalSecs approximates Bitcoin's target block interval.
+const avgBlockIntervalSecs = 600
+
+// blockEstimateMargin inflates the blocks-back estimate derived from
+// avgBlockIntervalSecs. Real block times run slightly under the 600s
+// target, so window/avgBlockIntervalSecs alone under-counts blocks and
+// would set startHeight more recent than the requested window, silently
+// dropping the oldest transactions in it. Over-fetching is safe — the
+// timestamp filter below trims the extra blocks back off — so this must
+// only ever round the estimate up, never down.
+const blockEstimateMargin = 1.2
+
+// GetTransactionHistory returns on-chain transactions within the given
+// look-back window. GetTransactions has no count-based pagination to
+// combine with Reversed the way ListPayments/ListInvoices do, so instead
+// this bounds the call with StartHeight, approximated from the window via
+// the average block interval, to avoid scanning the entire wallet history
+// just to filter it down client-side.
+func GetTransactionHistory(
+	client lnrpc.LightningClient, ctx context.Context,
+	window time.Duration) (proto.Message, error) {
+
+	info, err := client.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	blocksBack := int32(
+		blockEstimateMargin * window.Seconds() / avgBlockIntervalSecs,
+	)
+	startHeight := int32(info.BlockHeight) - blocksBack
+	if startHeight < 0 {
+		startHeight = 0
 	}
-	resp, err := client.ForwardingHistory(ctx, req)
+
+	resp, err := client.GetTransactions(ctx, &lnrpc.GetTransactionsRequest{
+		StartHeight: startHeight,
+	})
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-window).Unix()
+	txs := resp.Transactions[:0]
+	for _, tx := range resp.Transactions {
+		if tx.TimeStamp >= cutoff {
+			txs = append(txs, tx)
+		}
 	}
-	return resp
+	resp.Transactions = txs
+
+	return resp, nil
 }
 
 // reTimeRange matches systemd.time-like short negative timeranges, e.g. "-200s".
@@ -223,22 +810,23 @@ var secondsPer = map[string]int64{
 	"y": 31557600, // 365.25 days
 }
 
-// parseTime parses UNIX timestamps or short timeranges inspired by systemd
-// (when starting with "-"), e.g. "-1M" for one month (30.44 days) ago.
-func parseTime(s string, base time.Time) (uint64, error) {
-	if reTimeRange.MatchString(s) {
-		last := len(s) - 1
+// parseWindow parses a systemd-style short timerange (e.g. "-30d") into the
+// equivalent time.Duration looking back from now.
+func parseWindow(s string) (time.Duration, error) {
+	if !reTimeRange.MatchString(s) {
+		return 0, fmt.Errorf("%q is not a systemd-style duration such "+
+			"as -30d", s)
+	}
 
-		d, err := strconv.ParseInt(s[1:last], 10, 64)
-		if err != nil {
-			return uint64(0), err
-		}
+	last := len(s) - 1
 
-		mul := secondsPer[string(s[last])]
-		return uint64(base.Unix() - d*mul), nil
+	d, err := strconv.ParseInt(s[1:last], 10, 64)
+	if err != nil {
+		return 0, err
 	}
 
-	return strconv.ParseUint(s, 10, 64)
+	mul := secondsPer[string(s[last])]
+	return time.Duration(d*mul) * time.Second, nil
 }
 
 // getClientConn returns a rpc client instance to the caller